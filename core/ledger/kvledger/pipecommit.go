@@ -0,0 +1,227 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/validator/valinternal"
+	"github.com/hyperledger/fabric/protos/common"
+)
+
+// PipeCommitConfig controls the pipelined validate-then-commit ("pipecommit") execution path. When Enabled is
+// false, blocks are committed one at a time through the ordinary sequential path and the rest of this file is
+// unused.
+type PipeCommitConfig struct {
+	Enabled bool
+	// StageBufferSize bounds the channel between each pair of pipeline stages, which in turn bounds how far
+	// ahead of the commit stage the execute stage is allowed to run.
+	StageBufferSize int
+}
+
+// pipeCommitMetrics counts blocks that pass through (or are rejected by) each stage of the pipeline.
+type pipeCommitMetrics struct {
+	mu              sync.Mutex
+	executed        int
+	merged          int
+	verified        int
+	committed       int
+	verifyFailures  int
+	fallbackCommits int
+}
+
+func (m *pipeCommitMetrics) incr(counter *int) {
+	m.mu.Lock()
+	*counter++
+	m.mu.Unlock()
+}
+
+// blockJob carries a single block through the pipeline stages, accumulating the StateUpdate computed for it
+// along the way. done is closed by the commit stage once the job has either committed or failed.
+type blockJob struct {
+	block  *common.Block
+	rwsets []*valinternal.StateUpdate
+	update *valinternal.StateUpdate
+	err    error
+	done   chan struct{}
+}
+
+// CommitFuture is returned by CommitBlock and resolves once its block has been merged, verified, and
+// committed (or has failed one of those stages).
+type CommitFuture struct {
+	job *blockJob
+}
+
+// Wait blocks until the block behind this future has committed or failed, and returns the outcome.
+func (f *CommitFuture) Wait() error {
+	<-f.job.done
+	return f.job.err
+}
+
+// PipeCommitter overlaps the execute, merge, verify, and commit stages of block processing across
+// consecutive blocks: block N+1's execute stage can begin while block N is still being verified or
+// committed. The merge, verify, and commit stages each run in their own goroutine, connected by bounded
+// channels; the execute stage runs in the caller's goroutine, inside CommitBlock. If verify or commit fails
+// for a block, that block is not committed and its CommitFuture reports the error, and PipeCommitter falls
+// back to the ordinary sequential commit path for every later block, including ones already sitting in the
+// pipeline with an optimistically computed update, since none of that speculative work can be trusted once
+// an earlier block in the chain has failed.
+type PipeCommitter struct {
+	config PipeCommitConfig
+	db     privacyenabledstate.DB
+	// execute computes a per-transaction StateUpdate for a block without touching db (side-effect-free), so
+	// that it is safe to run concurrently with the commit of an earlier block.
+	execute func(block *common.Block) ([]*valinternal.StateUpdate, error)
+	// verify recomputes state hashes for the merged update and checks them against the block's stated hash.
+	verify func(block *common.Block, update *valinternal.StateUpdate) error
+	// sequentialCommit is the ordinary, non-pipelined commit path, used when pipelining is disabled and, after
+	// a verify or commit failure, for every later block.
+	sequentialCommit func(block *common.Block) error
+
+	metrics pipeCommitMetrics
+
+	executeCh chan *blockJob
+	mergeCh   chan *blockJob
+	verifyCh  chan *blockJob
+	commitCh  chan *blockJob
+
+	mu         sync.Mutex
+	fellBack   bool
+	commitErrs []error
+}
+
+// NewPipeCommitter constructs a PipeCommitter. execute, verify, and sequentialCommit are supplied by the
+// caller (the kvledger commit path) since they depend on the transaction manager and validator, which this
+// file does not otherwise need to know about.
+func NewPipeCommitter(config PipeCommitConfig, db privacyenabledstate.DB,
+	execute func(*common.Block) ([]*valinternal.StateUpdate, error),
+	verify func(*common.Block, *valinternal.StateUpdate) error,
+	sequentialCommit func(*common.Block) error) *PipeCommitter {
+	bufSize := config.StageBufferSize
+	if bufSize <= 0 {
+		bufSize = 1
+	}
+	p := &PipeCommitter{
+		config:           config,
+		db:               db,
+		execute:          execute,
+		verify:           verify,
+		sequentialCommit: sequentialCommit,
+		executeCh:        make(chan *blockJob, bufSize),
+		mergeCh:          make(chan *blockJob, bufSize),
+		verifyCh:         make(chan *blockJob, bufSize),
+		commitCh:         make(chan *blockJob, bufSize),
+	}
+	go p.mergeLoop()
+	go p.verifyLoop()
+	go p.commitLoop()
+	return p
+}
+
+// CommitBlock runs the (side-effect-free) execute stage for block and submits it to the merge/verify/commit
+// pipeline, or, once a verify/commit failure has triggered fallback, commits it sequentially right away.
+// CommitBlock returns as soon as the block has been handed off - it does not wait for merge, verify, or
+// commit to finish, so the caller may submit block N+1 while block N is still in one of those later stages.
+// executeCh, like the channels between the later stages, holds at most config.StageBufferSize blocks; the
+// next CommitBlock call blocks only once executeCh is full, which happens after the verify and commit
+// channels behind it have filled up as well, and stays blocked until the commit stage clears a block.
+func (p *PipeCommitter) CommitBlock(block *common.Block) (*CommitFuture, error) {
+	if !p.config.Enabled || p.isFallenBack() {
+		err := p.commitSequentially(block)
+		job := &blockJob{block: block, err: err, done: make(chan struct{})}
+		close(job.done)
+		return &CommitFuture{job: job}, nil
+	}
+
+	rwsets, err := p.execute(block)
+	if err != nil {
+		return nil, err
+	}
+	p.metrics.incr(&p.metrics.executed)
+
+	job := &blockJob{block: block, rwsets: rwsets, done: make(chan struct{})}
+	p.executeCh <- job
+	return &CommitFuture{job: job}, nil
+}
+
+func (p *PipeCommitter) mergeLoop() {
+	for job := range p.executeCh {
+		if job.err == nil && !p.isFallenBack() {
+			update := valinternal.NewStateUpdate()
+			for _, u := range job.rwsets {
+				if u != nil {
+					update.Merge(u)
+				}
+			}
+			job.update = update
+			p.metrics.incr(&p.metrics.merged)
+		}
+		p.verifyCh <- job
+	}
+}
+
+func (p *PipeCommitter) verifyLoop() {
+	for job := range p.verifyCh {
+		if job.err == nil && !p.isFallenBack() {
+			if err := p.verify(job.block, job.update); err != nil {
+				job.err = fmt.Errorf("pipecommit: block %d failed verification: %w", job.block.Header.Number, err)
+				p.metrics.incr(&p.metrics.verifyFailures)
+			} else {
+				p.metrics.incr(&p.metrics.verified)
+			}
+		}
+		p.commitCh <- job
+	}
+}
+
+// commitLoop is single-threaded over commitCh, so it sees every block in submission order: once it marks a
+// block as fallen back, every later block it dequeues - whether or not mergeLoop/verifyLoop already did
+// (now-untrusted) speculative work on it - is committed through the ordinary sequential path instead of
+// job.update.Apply, never silently applied off the back of a failed earlier block.
+func (p *PipeCommitter) commitLoop() {
+	for job := range p.commitCh {
+		switch {
+		case job.err != nil:
+			// already failed upstream (verify, or inherited from an earlier fallback); nothing to commit.
+		case p.isFallenBack():
+			job.err = p.commitSequentially(job.block)
+		default:
+			if err := job.update.Apply(p.db); err != nil {
+				job.err = err
+			} else {
+				p.metrics.incr(&p.metrics.committed)
+			}
+		}
+		if job.err != nil {
+			p.triggerFallback(job.err)
+		}
+		close(job.done)
+	}
+}
+
+func (p *PipeCommitter) triggerFallback(cause error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fellBack {
+		return
+	}
+	p.fellBack = true
+	p.commitErrs = append(p.commitErrs, cause)
+}
+
+func (p *PipeCommitter) isFallenBack() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fellBack
+}
+
+func (p *PipeCommitter) commitSequentially(block *common.Block) error {
+	p.metrics.incr(&p.metrics.fallbackCommits)
+	return p.sequentialCommit(block)
+}
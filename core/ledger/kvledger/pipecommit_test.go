@@ -0,0 +1,115 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kvledger
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/validator/valinternal"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/version"
+	"github.com/hyperledger/fabric/protos/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeCommitFakeDB only implements the method Apply exercises; embedding privacyenabledstate.DB satisfies the
+// rest of the interface without a full implementation.
+type pipeCommitFakeDB struct {
+	privacyenabledstate.DB
+	mu         sync.Mutex
+	applyCalls int
+}
+
+func (f *pipeCommitFakeDB) ApplyPrivacyAwareUpdates(updates *privacyenabledstate.UpdateBatch, height *version.Height) error {
+	f.mu.Lock()
+	f.applyCalls++
+	f.mu.Unlock()
+	return nil
+}
+
+func newBlock(num uint64) *common.Block {
+	return &common.Block{Header: &common.BlockHeader{Number: num}}
+}
+
+// TestCommitBlockDoesNotBlock verifies CommitBlock returns before the merge/verify/commit stages for a block
+// have run, which is the entire point of pipelining: without it, submitting block N+1 could never overlap
+// block N's commit.
+func TestCommitBlockDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	p := NewPipeCommitter(
+		PipeCommitConfig{Enabled: true, StageBufferSize: 4},
+		&pipeCommitFakeDB{},
+		func(block *common.Block) ([]*valinternal.StateUpdate, error) {
+			return []*valinternal.StateUpdate{valinternal.NewStateUpdate()}, nil
+		},
+		func(block *common.Block, update *valinternal.StateUpdate) error {
+			<-release
+			return nil
+		},
+		func(block *common.Block) error { return nil },
+	)
+
+	future, err := p.CommitBlock(newBlock(1))
+	require.NoError(t, err)
+
+	close(release)
+	require.NoError(t, future.Wait())
+}
+
+// TestCommitBlockFallsBackAfterVerifyFailure covers the review scenario: block 2 fails verification while
+// block 3 has already raced ahead through merge/verify. commitLoop is single-threaded over commitCh and
+// dequeues blocks in submission order, so by the time it reaches block 3 it has already marked the pipeline
+// as fallen back for block 2, and must commit block 3 sequentially instead of silently applying its
+// (now-untrusted) optimistically computed update.
+func TestCommitBlockFallsBackAfterVerifyFailure(t *testing.T) {
+	db := &pipeCommitFakeDB{}
+	var sequentialMu sync.Mutex
+	var sequential []uint64
+
+	p := NewPipeCommitter(
+		PipeCommitConfig{Enabled: true, StageBufferSize: 8},
+		db,
+		func(block *common.Block) ([]*valinternal.StateUpdate, error) {
+			return []*valinternal.StateUpdate{valinternal.NewStateUpdate()}, nil
+		},
+		func(block *common.Block, update *valinternal.StateUpdate) error {
+			if block.Header.Number == 2 {
+				return fmt.Errorf("simulated verify failure")
+			}
+			return nil
+		},
+		func(block *common.Block) error {
+			sequentialMu.Lock()
+			sequential = append(sequential, block.Header.Number)
+			sequentialMu.Unlock()
+			return nil
+		},
+	)
+
+	var futures []*CommitFuture
+	for n := uint64(1); n <= 3; n++ {
+		f, err := p.CommitBlock(newBlock(n))
+		require.NoError(t, err)
+		futures = append(futures, f)
+	}
+
+	assert.NoError(t, futures[0].Wait())
+	assert.Error(t, futures[1].Wait())
+	assert.NoError(t, futures[2].Wait())
+
+	sequentialMu.Lock()
+	defer sequentialMu.Unlock()
+	assert.Contains(t, sequential, uint64(3), "block 3 must be committed through the sequential fallback path, not applied directly")
+	assert.NotContains(t, sequential, uint64(1), "block 1 committed normally before the fallback was triggered")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	assert.Equal(t, 1, db.applyCalls, "only block 1 should ever reach job.update.Apply: block 2 failed verify and block 3 must take the sequential fallback path")
+}
@@ -0,0 +1,139 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statedb
+
+import (
+	"sync"
+)
+
+// prefetcherMaxEntries bounds the memory the shared prefetch cache can grow to. Once the cache is full,
+// newly completed prefetches are dropped rather than evicting in-flight work; callers simply fall back to a
+// point lookup for the keys that did not make it in.
+const prefetcherMaxEntries = 100000
+
+// Fetcher is the minimal subset of a state database that Prefetcher needs in order to warm its cache.
+// privacyenabledstate.DB satisfies it; the public statedb.VersionedDB has no GetValueHash and does not.
+type Fetcher interface {
+	GetState(namespace, key string) (*VersionedValue, error)
+	GetValueHash(namespace, collection string, keyHash []byte) (*VersionedValue, error)
+}
+
+// PrefetchKey identifies a single public or hashed key to warm in the prefetch cache. Collection is empty
+// for a public (non-private-data) key.
+type PrefetchKey struct {
+	Namespace  string
+	Collection string
+	Key        string
+}
+
+// Prefetcher hides statedb read latency behind transaction execution by warming a shared, concurrency-safe
+// cache for the write-set/read-set keys of upcoming transactions while earlier transactions in the block are
+// still being processed. retrieveLatestState consults this cache, falling back to a point lookup on a miss.
+// A single Prefetcher is safe to reuse across block boundaries so the validator/committer and the
+// endorsement-time simulation path can share the same warm cache.
+type Prefetcher struct {
+	db      Fetcher
+	workers int
+	sem     chan struct{}
+	wg      sync.WaitGroup
+
+	mu        sync.RWMutex
+	cache     map[PrefetchKey]*VersionedValue
+	cancelled map[PrefetchKey]struct{}
+}
+
+// NewPrefetcher constructs a Prefetcher that issues at most workers concurrent calls into db.
+func NewPrefetcher(db Fetcher, workers int) *Prefetcher {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &Prefetcher{
+		db:        db,
+		workers:   workers,
+		sem:       make(chan struct{}, workers),
+		cache:     make(map[PrefetchKey]*VersionedValue),
+		cancelled: make(map[PrefetchKey]struct{}),
+	}
+}
+
+// Prefetch asynchronously warms the cache for the given keys. It returns immediately; Get does not wait for
+// a pending prefetch either, so callers read whatever has already landed via Get and, on a miss, fall back
+// to a direct db read.
+func (p *Prefetcher) Prefetch(keys []PrefetchKey) {
+	for _, k := range keys {
+		p.wg.Add(1)
+		go func(k PrefetchKey) {
+			defer p.wg.Done()
+			p.sem <- struct{}{}
+			defer func() { <-p.sem }()
+
+			if p.alreadyCached(k) {
+				return
+			}
+
+			var vv *VersionedValue
+			var err error
+			if k.Collection == "" {
+				vv, err = p.db.GetState(k.Namespace, k.Key)
+			} else {
+				vv, err = p.db.GetValueHash(k.Namespace, k.Collection, []byte(k.Key))
+			}
+			if err != nil || vv == nil {
+				return
+			}
+			p.put(k, vv)
+		}(k)
+	}
+}
+
+// Cancel drops any prefetched value for a key whose owning transaction was subsequently marked invalid (or
+// already computed) upstream, and tombstones the key so that an in-flight Prefetch goroutine that is already
+// past its alreadyCached check cannot resurrect a stale value for it afterward. The tombstone is cleared on
+// the next Reset.
+func (p *Prefetcher) Cancel(k PrefetchKey) {
+	p.mu.Lock()
+	delete(p.cache, k)
+	p.cancelled[k] = struct{}{}
+	p.mu.Unlock()
+}
+
+// Get returns the prefetched value for a key, if the prefetch has completed and landed in the cache.
+func (p *Prefetcher) Get(ns, coll, key string) (*VersionedValue, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	vv, ok := p.cache[PrefetchKey{Namespace: ns, Collection: coll, Key: key}]
+	return vv, ok
+}
+
+// Reset clears the cache and waits for any in-flight prefetches to finish, so the Prefetcher can be reused
+// for the next block with a clean slate.
+func (p *Prefetcher) Reset() {
+	p.wg.Wait()
+	p.mu.Lock()
+	p.cache = make(map[PrefetchKey]*VersionedValue)
+	p.cancelled = make(map[PrefetchKey]struct{})
+	p.mu.Unlock()
+}
+
+func (p *Prefetcher) alreadyCached(k PrefetchKey) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.cache[k]
+	return ok
+}
+
+func (p *Prefetcher) put(k PrefetchKey, vv *VersionedValue) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.cancelled[k]; ok {
+		return
+	}
+	if len(p.cache) >= prefetcherMaxEntries {
+		return
+	}
+	p.cache[k] = vv
+}
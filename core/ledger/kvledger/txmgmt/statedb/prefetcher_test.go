@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package statedb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fetcherStub struct {
+	mu     sync.Mutex
+	pub    map[string]*VersionedValue
+	hashed map[string]*VersionedValue
+}
+
+func (f *fetcherStub) GetState(ns, key string) (*VersionedValue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pub[ns+"\x00"+key], nil
+}
+
+func (f *fetcherStub) GetValueHash(ns, coll string, keyHash []byte) (*VersionedValue, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hashed[ns+"\x00"+coll+"\x00"+string(keyHash)], nil
+}
+
+func TestPrefetcherWarmsCache(t *testing.T) {
+	fetcher := &fetcherStub{pub: map[string]*VersionedValue{
+		"ns1\x00key1": {Value: []byte("v1")},
+	}}
+	p := NewPrefetcher(fetcher, 4)
+
+	p.Prefetch([]PrefetchKey{{Namespace: "ns1", Key: "key1"}})
+	p.wg.Wait()
+
+	vv, ok := p.Get("ns1", "", "key1")
+	require.True(t, ok)
+	assert.Equal(t, []byte("v1"), vv.Value)
+}
+
+func TestPrefetcherCancelRemovesEntry(t *testing.T) {
+	fetcher := &fetcherStub{pub: map[string]*VersionedValue{"ns1\x00key1": {Value: []byte("v1")}}}
+	p := NewPrefetcher(fetcher, 4)
+
+	p.Prefetch([]PrefetchKey{{Namespace: "ns1", Key: "key1"}})
+	p.wg.Wait()
+
+	p.Cancel(PrefetchKey{Namespace: "ns1", Key: "key1"})
+	_, ok := p.Get("ns1", "", "key1")
+	assert.False(t, ok)
+}
+
+// TestPrefetcherCancelTombstonesInFlightPrefetch guards against a prefetch that is already past its
+// alreadyCached check when Cancel runs: without a tombstone, put() would resurrect the stale value right
+// after Cancel cleared it.
+func TestPrefetcherCancelTombstonesInFlightPrefetch(t *testing.T) {
+	fetcher := &fetcherStub{pub: map[string]*VersionedValue{"ns1\x00key1": {Value: []byte("stale")}}}
+	p := NewPrefetcher(fetcher, 4)
+	key := PrefetchKey{Namespace: "ns1", Key: "key1"}
+
+	p.Cancel(key)
+	p.put(key, &VersionedValue{Value: []byte("stale")})
+	_, ok := p.Get("ns1", "", "key1")
+	assert.False(t, ok, "put() must not resurrect a value for a cancelled key")
+}
+
+func TestPrefetcherResetClearsCacheAndTombstones(t *testing.T) {
+	fetcher := &fetcherStub{pub: map[string]*VersionedValue{"ns1\x00key1": {Value: []byte("v1")}}}
+	p := NewPrefetcher(fetcher, 4)
+	key := PrefetchKey{Namespace: "ns1", Key: "key1"}
+
+	p.Prefetch([]PrefetchKey{key})
+	p.wg.Wait()
+	p.Cancel(key)
+	p.Reset()
+
+	p.put(key, &VersionedValue{Value: []byte("v2")})
+	vv, ok := p.Get("ns1", "", "key1")
+	require.True(t, ok, "Reset must clear the tombstone so the key can be prefetched again next block")
+	assert.Equal(t, []byte("v2"), vv.Value)
+}
+
+func TestPrefetcherConcurrentPrefetchAndCancel(t *testing.T) {
+	fetcher := &fetcherStub{pub: map[string]*VersionedValue{}}
+	for i := 0; i < 100; i++ {
+		fetcher.pub["ns1\x00key"+string(rune('a'+i%26))] = &VersionedValue{Value: []byte("v")}
+	}
+	p := NewPrefetcher(fetcher, 8)
+
+	var keys []PrefetchKey
+	for i := 0; i < 100; i++ {
+		keys = append(keys, PrefetchKey{Namespace: "ns1", Key: "key" + string(rune('a'+i%26))})
+	}
+
+	p.Prefetch(keys)
+	for _, k := range keys[:50] {
+		p.Cancel(k)
+	}
+	p.wg.Wait()
+
+	for _, k := range keys[:50] {
+		_, ok := p.Get(k.Namespace, k.Collection, k.Key)
+		assert.False(t, ok, "cancelled key %+v must not be present after all prefetches settle", k)
+	}
+}
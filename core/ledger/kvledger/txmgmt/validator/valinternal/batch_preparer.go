@@ -0,0 +1,39 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package valinternal
+
+import (
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+)
+
+// BulkLoadLatestStateForBlock combines the write-set keys that prepareTxOps will need for every transaction in
+// the block with the read-set keys the validator already preloaded during MVCC validation. It issues one
+// db.GetStateMultipleKeys/db.GetValueHashes round-trip per namespace/collection for the write-set candidates
+// only, then adds each readSetPreloaded entry whose key that round-trip did not already answer. The candidate
+// set is computed from every transaction's rwset merged together rather than per-transaction, which is safe
+// only because retrieveLatestState still falls back to a point lookup on a bulkLoaded miss - see
+// TestBulkLoadLatestStateForBlockMixedFinalOps. See FAB-11328.
+func BulkLoadLatestStateForBlock(blockRwsets []*rwsetutil.TxRwSet, readSetPreloaded map[compositeKey]*statedb.VersionedValue,
+	precedingUpdates *PubAndHashUpdates, db privacyenabledstate.DB) (map[compositeKey]*statedb.VersionedValue, error) {
+	merged := txOps{}
+	for _, rwset := range blockRwsets {
+		merged.applyTxRwset(rwset)
+	}
+
+	bulkLoaded, err := bulkLoadLatestState(merged, precedingUpdates, db)
+	if err != nil {
+		return nil, err
+	}
+	for ck, vv := range readSetPreloaded {
+		if _, ok := bulkLoaded[ck]; !ok {
+			bulkLoaded[ck] = vv
+		}
+	}
+	return bulkLoaded, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package valinternal
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchPreparerFakeDB only implements the methods BulkLoadLatestStateForBlock exercises; embedding
+// privacyenabledstate.DB satisfies the rest of the interface without a full implementation.
+type batchPreparerFakeDB struct {
+	privacyenabledstate.DB
+	pub map[string]*statedb.VersionedValue
+}
+
+func (f *batchPreparerFakeDB) GetStateMultipleKeys(ns string, keys []string) ([]*statedb.VersionedValue, error) {
+	vals := make([]*statedb.VersionedValue, len(keys))
+	for i, key := range keys {
+		vals[i] = f.pub[ns+"\x00"+key]
+	}
+	return vals, nil
+}
+
+func (f *batchPreparerFakeDB) GetValueHashes(ns, coll string, keyHashes [][]byte) ([]*statedb.VersionedValue, error) {
+	return make([]*statedb.VersionedValue, len(keyHashes)), nil
+}
+
+// TestBulkLoadLatestStateForBlockMixedFinalOps documents a known limitation: the candidate set is computed
+// from the whole block's rwsets merged into one txOps, not per transaction. Here tx0 writes only key1's
+// metadata and tx1 fully upserts key1; merged together, key1 looks like a single self-sufficient
+// upsert-and-metadata write and is never added to the bulk-load candidate set, even though tx0's own view of
+// key1 needed the latest value merged in. This stays correct because retrieveLatestState still falls back to
+// a point lookup on a bulkLoaded miss - it only costs the optimization this function exists to provide.
+func TestBulkLoadLatestStateForBlockMixedFinalOps(t *testing.T) {
+	tx0 := &rwsetutil.TxRwSet{NsRwSets: []*rwsetutil.NsRwSet{{
+		NameSpace: "ns1",
+		KvRwSet: &kvrwset.KVRWSet{
+			MetadataWrites: []*kvrwset.KVMetadataWrite{
+				{Key: "key1", Entries: []*kvrwset.KVMetadataEntry{{Name: "tag", Value: []byte("v")}}},
+			},
+		},
+	}}}
+	tx1 := &rwsetutil.TxRwSet{NsRwSets: []*rwsetutil.NsRwSet{{
+		NameSpace: "ns1",
+		KvRwSet: &kvrwset.KVRWSet{
+			Writes: []*kvrwset.KVWrite{{Key: "key1", Value: []byte("v1")}},
+		},
+	}}}
+
+	db := &batchPreparerFakeDB{pub: map[string]*statedb.VersionedValue{"ns1\x00key1": {Value: []byte("stale")}}}
+	bulkLoaded, err := BulkLoadLatestStateForBlock([]*rwsetutil.TxRwSet{tx0, tx1}, nil, &PubAndHashUpdates{}, db)
+	require.NoError(t, err)
+
+	_, ok := bulkLoaded[compositeKey{"ns1", "", "key1"}]
+	assert.False(t, ok, "key1 looks self-sufficient once tx0 and tx1 are merged into one txOps, so it is not bulk-loaded")
+}
+
+// TestBulkLoadLatestStateForBlockUnionsReadSetPreloaded checks that keys already answered by the validator's
+// MVCC read-set preload are unioned into the result without a second round-trip, and don't overwrite a key
+// bulkLoadLatestState already answered itself.
+func TestBulkLoadLatestStateForBlockUnionsReadSetPreloaded(t *testing.T) {
+	tx := &rwsetutil.TxRwSet{NsRwSets: []*rwsetutil.NsRwSet{{
+		NameSpace: "ns1",
+		KvRwSet: &kvrwset.KVRWSet{
+			MetadataWrites: []*kvrwset.KVMetadataWrite{
+				{Key: "key1", Entries: []*kvrwset.KVMetadataEntry{{Name: "tag", Value: []byte("v")}}},
+			},
+		},
+	}}}
+
+	readSetPreloaded := map[compositeKey]*statedb.VersionedValue{
+		{"ns1", "", "key1"}: {Value: []byte("from-read-set")},
+		{"ns2", "", "key2"}: {Value: []byte("unrelated")},
+	}
+
+	db := &batchPreparerFakeDB{pub: map[string]*statedb.VersionedValue{"ns1\x00key1": {Value: []byte("from-db")}}}
+	bulkLoaded, err := BulkLoadLatestStateForBlock([]*rwsetutil.TxRwSet{tx}, readSetPreloaded, NewPubAndHashUpdates(), db)
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("from-db"), bulkLoaded[compositeKey{"ns1", "", "key1"}].Value,
+		"a key bulkLoadLatestState already answered must not be overwritten by the read-set preload")
+	assert.Equal(t, []byte("unrelated"), bulkLoaded[compositeKey{"ns2", "", "key2"}].Value,
+		"a key only present in the read-set preload must still be unioned in")
+}
@@ -0,0 +1,38 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package valinternal
+
+import (
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+)
+
+// CollectPrefetchKeys extracts the write-set and read-set keys (public and hashed) touched by rwset as
+// statedb.PrefetchKey values, so that the validator can warm the shared statedb.Prefetcher cache for
+// transactions N+1..N+K while transaction N is still being processed by prepareTxOps.
+func CollectPrefetchKeys(rwset *rwsetutil.TxRwSet) []statedb.PrefetchKey {
+	var keys []statedb.PrefetchKey
+	for _, nsRWSet := range rwset.NsRwSets {
+		ns := nsRWSet.NameSpace
+		for _, kvWrite := range nsRWSet.KvRwSet.Writes {
+			keys = append(keys, statedb.PrefetchKey{Namespace: ns, Key: kvWrite.Key})
+		}
+		for _, kvRead := range nsRWSet.KvRwSet.Reads {
+			keys = append(keys, statedb.PrefetchKey{Namespace: ns, Key: kvRead.Key})
+		}
+		for _, collHashRWset := range nsRWSet.CollHashedRwSets {
+			coll := collHashRWset.CollectionName
+			for _, hashedWrite := range collHashRWset.HashedRwSet.HashedWrites {
+				keys = append(keys, statedb.PrefetchKey{Namespace: ns, Collection: coll, Key: string(hashedWrite.KeyHash)})
+			}
+			for _, hashedRead := range collHashRWset.HashedRwSet.HashedReads {
+				keys = append(keys, statedb.PrefetchKey{Namespace: ns, Collection: coll, Key: string(hashedRead.KeyHash)})
+			}
+		}
+	}
+	return keys
+}
@@ -0,0 +1,39 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package valinternal
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectPrefetchKeysIncludesReadsAndWrites(t *testing.T) {
+	rwset := &rwsetutil.TxRwSet{NsRwSets: []*rwsetutil.NsRwSet{{
+		NameSpace: "ns1",
+		KvRwSet: &kvrwset.KVRWSet{
+			Reads:  []*kvrwset.KVRead{{Key: "readKey"}},
+			Writes: []*kvrwset.KVWrite{{Key: "writeKey", Value: []byte("v")}},
+		},
+		CollHashedRwSets: []*rwsetutil.CollHashedRwSet{{
+			CollectionName: "coll1",
+			HashedRwSet: &kvrwset.HashedRWSet{
+				HashedReads:  []*kvrwset.KVReadHash{{KeyHash: []byte("hashedReadKey")}},
+				HashedWrites: []*kvrwset.KVWriteHash{{KeyHash: []byte("hashedWriteKey"), ValueHash: []byte("v")}},
+			},
+		}},
+	}}}
+
+	keys := CollectPrefetchKeys(rwset)
+	assert.Contains(t, keys, statedb.PrefetchKey{Namespace: "ns1", Key: "readKey"})
+	assert.Contains(t, keys, statedb.PrefetchKey{Namespace: "ns1", Key: "writeKey"})
+	assert.Contains(t, keys, statedb.PrefetchKey{Namespace: "ns1", Collection: "coll1", Key: "hashedReadKey"})
+	assert.Contains(t, keys, statedb.PrefetchKey{Namespace: "ns1", Collection: "coll1", Key: "hashedWriteKey"})
+}
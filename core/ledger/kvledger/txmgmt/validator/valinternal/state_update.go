@@ -0,0 +1,219 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package valinternal
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/version"
+)
+
+// StateUpdate holds everything produced by running prepareTxOps over one transaction (or, once several have
+// been combined with Merge, over a block): the public writes (by
+// namespace), the hashed writes (by namespace/collection), the deletions, the "origin" set of VersionedValues
+// read while merging them, and the height of the last transaction folded in (used as the commit savepoint).
+type StateUpdate struct {
+	PubUpdates  map[string]map[string]*statedb.VersionedValue
+	HashUpdates map[compositeKey]*statedb.VersionedValue
+	Deletes     map[compositeKey]bool
+	Origin      map[compositeKey]*statedb.VersionedValue
+	Height      *version.Height
+}
+
+// NewStateUpdate returns an empty StateUpdate ready to have one or more transactions' txOps folded into it.
+func NewStateUpdate() *StateUpdate {
+	return &StateUpdate{
+		PubUpdates:  map[string]map[string]*statedb.VersionedValue{},
+		HashUpdates: map[compositeKey]*statedb.VersionedValue{},
+		Deletes:     map[compositeKey]bool{},
+		Origin:      map[compositeKey]*statedb.VersionedValue{},
+	}
+}
+
+// addTxOps folds the per-transaction txops produced by prepareTxOps, along with the origin values read while
+// preparing them, into this StateUpdate.
+func (u *StateUpdate) addTxOps(txops txOps, txht *version.Height, origin map[compositeKey]*statedb.VersionedValue) {
+	u.Height = txht
+	for ck, keyop := range txops {
+		if keyop.isDelete() {
+			u.deleteKey(ck)
+			continue
+		}
+		vv := &statedb.VersionedValue{Value: keyop.value, Metadata: keyop.metadata, Version: txht}
+		u.updateKey(ck, vv)
+	}
+	for ck, vv := range origin {
+		if _, ok := u.Origin[ck]; !ok {
+			u.Origin[ck] = vv
+		}
+	}
+}
+
+// updateKey records a write for ck, clearing any previously recorded delete for the same key so that a
+// delete-then-recreate within the same block (or across a Merge) is not lost.
+func (u *StateUpdate) updateKey(ck compositeKey, vv *statedb.VersionedValue) {
+	delete(u.Deletes, ck)
+	if ck.coll == "" {
+		nsUpdates, ok := u.PubUpdates[ck.ns]
+		if !ok {
+			nsUpdates = map[string]*statedb.VersionedValue{}
+			u.PubUpdates[ck.ns] = nsUpdates
+		}
+		nsUpdates[ck.key] = vv
+	} else {
+		u.HashUpdates[ck] = vv
+	}
+}
+
+// deleteKey records a delete for ck, clearing any previously recorded write for the same key so that a
+// recreate-then-delete within the same block (or across a Merge) is not lost.
+func (u *StateUpdate) deleteKey(ck compositeKey) {
+	u.Deletes[ck] = true
+	if ck.coll == "" {
+		delete(u.PubUpdates[ck.ns], ck.key)
+	} else {
+		delete(u.HashUpdates, ck)
+	}
+}
+
+// Apply writes every public update, hashed update, and deletion captured by this StateUpdate to db as a
+// single batch, recording u.Height as the commit savepoint.
+func (u *StateUpdate) Apply(db privacyenabledstate.DB) error {
+	updates := privacyenabledstate.NewUpdateBatch()
+	for ns, nsUpdates := range u.PubUpdates {
+		for key, vv := range nsUpdates {
+			updates.PubUpdates.Update(ns, key, vv)
+		}
+	}
+	for ck, vv := range u.HashUpdates {
+		updates.HashUpdates.Update(ck.ns, ck.coll, ck.key, vv)
+	}
+	for ck := range u.Deletes {
+		if ck.coll == "" {
+			updates.PubUpdates.Delete(ck.ns, ck.key, u.Height)
+		} else {
+			updates.HashUpdates.Delete(ck.ns, ck.coll, ck.key, u.Height)
+		}
+	}
+	return db.ApplyPrivacyAwareUpdates(updates, u.Height)
+}
+
+// Merge folds other into u, with other's writes and deletes (and Height) taking precedence for any key they
+// share with u, mirroring the effect of applying other after u. Origin is the exception: u's entry for a key
+// is kept, since it was read before other's.
+func (u *StateUpdate) Merge(other *StateUpdate) {
+	for ns, nsUpdates := range other.PubUpdates {
+		for key, vv := range nsUpdates {
+			u.updateKey(compositeKey{ns, "", key}, vv)
+		}
+	}
+	for ck, vv := range other.HashUpdates {
+		u.updateKey(ck, vv)
+	}
+	for ck := range other.Deletes {
+		u.deleteKey(ck)
+	}
+	for ck, vv := range other.Origin {
+		if _, ok := u.Origin[ck]; !ok {
+			u.Origin[ck] = vv
+		}
+	}
+	if other.Height != nil {
+		u.Height = other.Height
+	}
+}
+
+// compositeKeyDTO is the JSON wire representation of a compositeKey. compositeKey's fields are unexported, so
+// without an explicit MarshalJSON/UnmarshalJSON pair encoding/json would silently encode every compositeKey
+// as "{}" and every round-tripped entry would collapse onto the zero-value key.
+type compositeKeyDTO struct {
+	Ns   string `json:"ns"`
+	Coll string `json:"coll"`
+	Key  string `json:"key"`
+}
+
+// MarshalJSON implements json.Marshaler for compositeKey.
+func (ck compositeKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(compositeKeyDTO{Ns: ck.ns, Coll: ck.coll, Key: ck.key})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for compositeKey.
+func (ck *compositeKey) UnmarshalJSON(b []byte) error {
+	var dto compositeKeyDTO
+	if err := json.Unmarshal(b, &dto); err != nil {
+		return err
+	}
+	*ck = compositeKey{dto.Ns, dto.Coll, dto.Key}
+	return nil
+}
+
+// serializableStateUpdate is the wire format for StateUpdate: the map keys used internally (compositeKey) are
+// not valid JSON object keys, so entries are flattened into slices before marshaling.
+type serializableStateUpdate struct {
+	PubUpdates  []serializableKV `json:"pub_updates"`
+	HashUpdates []serializableKV `json:"hash_updates"`
+	Deletes     []compositeKey   `json:"deletes"`
+	Origin      []serializableKV `json:"origin"`
+	Height      *version.Height  `json:"height,omitempty"`
+}
+
+type serializableKV struct {
+	Key   compositeKey            `json:"key"`
+	Value *statedb.VersionedValue `json:"value"`
+}
+
+// Serialize encodes the StateUpdate so it can be logged, shipped to a peer joining via state transfer, or
+// diffed against another peer's independently computed update for drift detection.
+func (u *StateUpdate) Serialize() ([]byte, error) {
+	s := serializableStateUpdate{}
+	for ns, nsUpdates := range u.PubUpdates {
+		for key, vv := range nsUpdates {
+			s.PubUpdates = append(s.PubUpdates, serializableKV{compositeKey{ns, "", key}, vv})
+		}
+	}
+	for ck, vv := range u.HashUpdates {
+		s.HashUpdates = append(s.HashUpdates, serializableKV{ck, vv})
+	}
+	for ck := range u.Deletes {
+		s.Deletes = append(s.Deletes, ck)
+	}
+	for ck, vv := range u.Origin {
+		s.Origin = append(s.Origin, serializableKV{ck, vv})
+	}
+	s.Height = u.Height
+	return json.Marshal(s)
+}
+
+// Deserialize decodes a StateUpdate previously produced by Serialize.
+func (u *StateUpdate) Deserialize(b []byte) error {
+	s := serializableStateUpdate{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*u = *NewStateUpdate()
+	for _, kv := range s.PubUpdates {
+		nsUpdates, ok := u.PubUpdates[kv.Key.ns]
+		if !ok {
+			nsUpdates = map[string]*statedb.VersionedValue{}
+			u.PubUpdates[kv.Key.ns] = nsUpdates
+		}
+		nsUpdates[kv.Key.key] = kv.Value
+	}
+	for _, kv := range s.HashUpdates {
+		u.HashUpdates[kv.Key] = kv.Value
+	}
+	for _, ck := range s.Deletes {
+		u.Deletes[ck] = true
+	}
+	for _, kv := range s.Origin {
+		u.Origin[kv.Key] = kv.Value
+	}
+	u.Height = s.Height
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package valinternal
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateUpdateDeleteThenRecreate(t *testing.T) {
+	u := NewStateUpdate()
+	ck := compositeKey{"ns1", "", "key1"}
+
+	u.deleteKey(ck)
+	assert.True(t, u.Deletes[ck])
+
+	u.updateKey(ck, &statedb.VersionedValue{Value: []byte("v1")})
+	assert.False(t, u.Deletes[ck], "a later write for the same key must clear the earlier delete")
+	assert.Equal(t, []byte("v1"), u.PubUpdates["ns1"]["key1"].Value)
+}
+
+func TestStateUpdateRecreateThenDelete(t *testing.T) {
+	u := NewStateUpdate()
+	ck := compositeKey{"ns1", "coll1", "key1"}
+
+	u.updateKey(ck, &statedb.VersionedValue{Value: []byte("v1")})
+	assert.Equal(t, []byte("v1"), u.HashUpdates[ck].Value)
+
+	u.deleteKey(ck)
+	assert.True(t, u.Deletes[ck])
+	_, stillPresent := u.HashUpdates[ck]
+	assert.False(t, stillPresent, "a later delete for the same key must clear the earlier write")
+}
+
+func TestStateUpdateMergeDeleteThenRecreateAcrossUpdates(t *testing.T) {
+	ck := compositeKey{"ns1", "", "key1"}
+
+	base := NewStateUpdate()
+	base.deleteKey(ck)
+
+	later := NewStateUpdate()
+	later.updateKey(ck, &statedb.VersionedValue{Value: []byte("recreated")})
+
+	base.Merge(later)
+
+	assert.False(t, base.Deletes[ck], "merging in a later recreate must clear the earlier delete")
+	if assert.Contains(t, base.PubUpdates, "ns1") {
+		assert.Equal(t, []byte("recreated"), base.PubUpdates["ns1"]["key1"].Value)
+	}
+}
+
+func TestStateUpdateMergeRecreateThenDeleteAcrossUpdates(t *testing.T) {
+	ck := compositeKey{"ns1", "", "key1"}
+
+	base := NewStateUpdate()
+	base.updateKey(ck, &statedb.VersionedValue{Value: []byte("v1")})
+
+	later := NewStateUpdate()
+	later.deleteKey(ck)
+
+	base.Merge(later)
+
+	assert.True(t, base.Deletes[ck])
+	nsUpdates := base.PubUpdates["ns1"]
+	_, stillPresent := nsUpdates[ck.key]
+	assert.False(t, stillPresent, "merging in a later delete must clear the earlier write")
+}
+
+func TestStateUpdateSerializeDeserializeRoundTrip(t *testing.T) {
+	u := NewStateUpdate()
+	u.updateKey(compositeKey{"ns1", "", "pubKey"}, &statedb.VersionedValue{Value: []byte("pubVal"), Metadata: []byte("md")})
+	u.updateKey(compositeKey{"ns1", "coll1", "hashKey"}, &statedb.VersionedValue{Value: []byte("hashVal")})
+	u.deleteKey(compositeKey{"ns2", "", "deletedKey"})
+	u.Origin[compositeKey{"ns1", "", "pubKey"}] = &statedb.VersionedValue{Value: []byte("origVal")}
+
+	b, err := u.Serialize()
+	assert.NoError(t, err)
+
+	round := &StateUpdate{}
+	assert.NoError(t, round.Deserialize(b))
+
+	if assert.Contains(t, round.PubUpdates, "ns1") {
+		assert.Equal(t, []byte("pubVal"), round.PubUpdates["ns1"]["pubKey"].Value)
+		assert.Equal(t, []byte("md"), round.PubUpdates["ns1"]["pubKey"].Metadata)
+	}
+	hashedVal, ok := round.HashUpdates[compositeKey{"ns1", "coll1", "hashKey"}]
+	if assert.True(t, ok, "hashed key must round-trip with its full composite key intact") {
+		assert.Equal(t, []byte("hashVal"), hashedVal.Value)
+	}
+	assert.True(t, round.Deletes[compositeKey{"ns2", "", "deletedKey"}])
+	originVal, ok := round.Origin[compositeKey{"ns1", "", "pubKey"}]
+	if assert.True(t, ok) {
+		assert.Equal(t, []byte("origVal"), originVal.Value)
+	}
+}
@@ -7,6 +7,9 @@ SPDX-License-Identifier: Apache-2.0
 package valinternal
 
 import (
+	"runtime"
+	"sync"
+
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/privacyenabledstate"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
@@ -15,81 +18,202 @@ import (
 	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
 )
 
-func prepareTxOps(rwset *rwsetutil.TxRwSet, txht *version.Height,
-	precedingUpdates *PubAndHashUpdates, db privacyenabledstate.DB) (txOps, error) {
+// ValidatorParallelism bounds the number of namespaces (in applyTxRwset) and composite keys (in the
+// retrieveLatestState merge loop of prepareTxOps) processed concurrently. Defaults to GOMAXPROCS; set to 1 to
+// fall back to the original single-threaded behavior.
+var ValidatorParallelism = runtime.GOMAXPROCS(0)
+
+// prepareTxOps computes the txOps for a single transaction's rwset and returns them, along with the
+// pre-read "origin" values used to merge them, as a new *StateUpdate holding this transaction's changes
+// only; a block-wide update is built by combining the per-transaction ones with Merge. upcoming holds the rwsets
+// of the next K transactions in the block (as already decoded by the caller's MVCC validation pass, if any);
+// before doing its own work for rwset, prepareTxOps kicks off an asynchronous prefetch of upcoming's
+// write-set and read-set keys into prefetcher, so that by the time those transactions reach prepareTxOps
+// themselves, retrieveLatestState may already find their values warm in the cache.
+func prepareTxOps(rwset *rwsetutil.TxRwSet, txht *version.Height, precedingUpdates *PubAndHashUpdates,
+	db privacyenabledstate.DB, prefetcher *statedb.Prefetcher, upcoming []*rwsetutil.TxRwSet) (*StateUpdate, error) {
+	if prefetcher != nil && len(upcoming) > 0 {
+		var keys []statedb.PrefetchKey
+		for _, u := range upcoming {
+			keys = append(keys, CollectPrefetchKeys(u)...)
+		}
+		prefetcher.Prefetch(keys)
+	}
+
 	txops := txOps{}
 	txops.applyTxRwset(rwset)
 	//logger.Debugf("prepareTxOps() txops after applying raw rwset=%#v", spew.Sdump(txops))
+
+	bulkLoaded, err := bulkLoadLatestState(txops, precedingUpdates, db)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := map[compositeKey]*statedb.VersionedValue{}
+
+	// candidate keys still need the latest committed value/metadata merged in; they are independent of one
+	// another, so fan the merge out across a worker pool sized by ValidatorParallelism rather than retrieving
+	// and merging one key at a time.
+	var candidates []compositeKey
 	for ck, keyop := range txops {
-		// check if the final state of the key, value and metadata, is already present in the transaction, then skip
-		// otherwise we need to retrieve latest state and merge in the current value or metadata update
 		if keyop.isDelete() || keyop.isUpsertAndMetadataUpdate() {
 			continue
 		}
+		candidates = append(candidates, ck)
+	}
 
-		latestVal, err := retrieveLatestState(ck.ns, ck.coll, ck.key, precedingUpdates, db)
-		if err != nil {
-			return nil, err
-		}
+	mergeParallelism := ValidatorParallelism
+	if mergeParallelism < 1 {
+		mergeParallelism = 1
+	}
+	var (
+		mergeWG  sync.WaitGroup
+		mergeMu  sync.Mutex
+		mergeSem = make(chan struct{}, mergeParallelism)
+		toDelete []compositeKey
+		firstErr error
+	)
+	for _, ck := range candidates {
+		ck, keyop := ck, txops[ck]
+		mergeWG.Add(1)
+		mergeSem <- struct{}{}
+		go func() {
+			defer mergeWG.Done()
+			defer func() { <-mergeSem }()
 
-		// check if only value is updated in the current transaction then merge the metadata from last committed state
-		if keyop.isOnlyUpsert() {
+			latestVal, err := retrieveLatestState(ck.ns, ck.coll, ck.key, precedingUpdates, bulkLoaded, prefetcher, db)
+			if err != nil {
+				mergeMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mergeMu.Unlock()
+				return
+			}
 			if latestVal != nil {
-				keyop.metadata = latestVal.Metadata
+				mergeMu.Lock()
+				origin[ck] = latestVal
+				mergeMu.Unlock()
 			}
-			continue
-		}
 
-		// only metadata is updated in the current transaction. Merge the value from the last committed state
-		// If the key does not exist in the last state, make this key as noop in current transaction
-		if latestVal != nil {
-			keyop.value = latestVal.Value
-		} else {
-			delete(txops, ck)
-		}
+			// check if only value is updated in the current transaction then merge the metadata from last committed state
+			if keyop.isOnlyUpsert() {
+				if latestVal != nil {
+					keyop.metadata = latestVal.Metadata
+				}
+				return
+			}
+
+			// only metadata is updated in the current transaction. Merge the value from the last committed state.
+			// If the key does not exist in the last state, mark this key as a noop in the current transaction.
+			if latestVal != nil {
+				keyop.value = latestVal.Value
+			} else {
+				mergeMu.Lock()
+				toDelete = append(toDelete, ck)
+				mergeMu.Unlock()
+			}
+		}()
+	}
+	mergeWG.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	for _, ck := range toDelete {
+		delete(txops, ck)
 	}
 	//logger.Debugf("prepareTxOps() txops after final processing=%#v", spew.Sdump(txops))
-	return txops, nil
+
+	// the values/metadata computed above for these keys are now authoritative for the rest of the block (once
+	// merged by the caller into precedingUpdates), so any value the prefetcher warmed for them is stale and
+	// must not be served to a later transaction in the block.
+	if prefetcher != nil {
+		for ck := range txops {
+			prefetcher.Cancel(statedb.PrefetchKey{Namespace: ck.ns, Collection: ck.coll, Key: ck.key})
+		}
+	}
+
+	update := NewStateUpdate()
+	update.addTxOps(txops, txht, origin)
+	return update, nil
 }
 
-// applyTxRwset records the upsertion/deletion of a kv and updatation/deletion
-// of asociated metadata present in a txrwset
+// applyTxRwset records the upsertion/deletion of a kv and updatation/deletion of asociated metadata present
+// in a txrwset. NsRwSets are independent of one another, so when ValidatorParallelism allows more than one
+// worker, the rwset spans more than one namespace, and txops is still empty, each namespace is applied to its
+// own shard of txOps concurrently and the shards are copied into txops afterwards, in the namespaces'
+// original order. A non-empty txops (as when several transactions' rwsets are folded into one) is applied to
+// serially, so that each write combines with the entry already recorded for its key.
 func (txops txOps) applyTxRwset(rwset *rwsetutil.TxRwSet) error {
-	for _, nsRWSet := range rwset.NsRwSets {
-		ns := nsRWSet.NameSpace
-		for _, kvWrite := range nsRWSet.KvRwSet.Writes {
-			txops.applyKVWrite(ns, "", kvWrite)
-		}
-		for _, kvMetadataWrite := range nsRWSet.KvRwSet.MetadataWrites {
-			txops.applyMetadata(ns, "", kvMetadataWrite)
-		}
-
-		// apply collection level kvwrite and kvMetadataWrite
-		for _, collHashRWset := range nsRWSet.CollHashedRwSets {
-			coll := collHashRWset.CollectionName
-			for _, hashedWrite := range collHashRWset.HashedRwSet.HashedWrites {
-				txops.applyKVWrite(ns, coll,
-					&kvrwset.KVWrite{
-						Key:      string(hashedWrite.KeyHash),
-						Value:    hashedWrite.ValueHash,
-						IsDelete: hashedWrite.IsDelete,
-					},
-				)
-			}
+	if ValidatorParallelism <= 1 || len(rwset.NsRwSets) <= 1 || len(txops) > 0 {
+		for _, nsRWSet := range rwset.NsRwSets {
+			applyNsRwSet(txops, nsRWSet)
+		}
+		return nil
+	}
 
-			for _, metadataWrite := range collHashRWset.HashedRwSet.MetadataWrites {
-				txops.applyMetadata(ns, coll,
-					&kvrwset.KVMetadataWrite{
-						Key:     string(metadataWrite.KeyHash),
-						Entries: metadataWrite.Entries,
-					},
-				)
-			}
+	shards := make([]txOps, len(rwset.NsRwSets))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, ValidatorParallelism)
+	for i, nsRWSet := range rwset.NsRwSets {
+		i, nsRWSet := i, nsRWSet
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			shard := txOps{}
+			applyNsRwSet(shard, nsRWSet)
+			shards[i] = shard
+		}()
+	}
+	wg.Wait()
+
+	for _, shard := range shards {
+		for ck, op := range shard {
+			txops[ck] = op
 		}
 	}
 	return nil
 }
 
+// applyNsRwSet applies a single namespace's kv/metadata writes, including its hashed, collection-level
+// writes, to txops in their original rwset order.
+func applyNsRwSet(txops txOps, nsRWSet *rwsetutil.NsRwSet) {
+	ns := nsRWSet.NameSpace
+
+	for _, kvWrite := range nsRWSet.KvRwSet.Writes {
+		txops.applyKVWrite(ns, "", kvWrite)
+	}
+	for _, kvMetadataWrite := range nsRWSet.KvRwSet.MetadataWrites {
+		txops.applyMetadata(ns, "", kvMetadataWrite)
+	}
+
+	// apply collection level kvwrite and kvMetadataWrite
+	for _, collHashRWset := range nsRWSet.CollHashedRwSets {
+		coll := collHashRWset.CollectionName
+
+		for _, hashedWrite := range collHashRWset.HashedRwSet.HashedWrites {
+			txops.applyKVWrite(ns, coll,
+				&kvrwset.KVWrite{
+					Key:      string(hashedWrite.KeyHash),
+					Value:    hashedWrite.ValueHash,
+					IsDelete: hashedWrite.IsDelete,
+				},
+			)
+		}
+
+		for _, metadataWrite := range collHashRWset.HashedRwSet.MetadataWrites {
+			txops.applyMetadata(ns, coll,
+				&kvrwset.KVMetadataWrite{
+					Key:     string(metadataWrite.KeyHash),
+					Entries: metadataWrite.Entries,
+				},
+			)
+		}
+	}
+}
+
 // applyKVWrite records upsertion/deletion of a kvwrite
 func (txops txOps) applyKVWrite(ns, coll string, kvWrite *kvrwset.KVWrite) {
 	if kvWrite.IsDelete {
@@ -113,18 +237,24 @@ func (txops txOps) applyMetadata(ns, coll string, metadataWrite *kvrwset.KVMetad
 	return nil
 }
 
-// retrieveLatestState returns the value of the key from the precedingUpdates (if the key was operated upon by a previous tran in the block).
-// If the key not present in the precedingUpdates, then this function, pulls the latest value from statedb
-// TODO FAB-11328, pulling from state for (especially for couchdb) will pay significant performance penalty so a bulkload would be helpful.
-// Further, all the keys that gets written will be required to pull from statedb by vscc for endorsement policy check (in the case of key level
-// endorsement) and hence, the bulkload should be combined
-func retrieveLatestState(ns, coll, key string,
-	precedingUpdates *PubAndHashUpdates, db privacyenabledstate.DB) (*statedb.VersionedValue, error) {
+// retrieveLatestState returns the value of the key from precedingUpdates (if the key was operated upon by a
+// previous tran in the block), else the bulkLoaded cache, else the shared background prefetch cache, and only
+// falls back to a point lookup against statedb (FAB-11328) as a last resort.
+func retrieveLatestState(ns, coll, key string, precedingUpdates *PubAndHashUpdates,
+	bulkLoaded map[compositeKey]*statedb.VersionedValue, prefetcher *statedb.Prefetcher, db privacyenabledstate.DB) (*statedb.VersionedValue, error) {
 	var vv *statedb.VersionedValue
 	var err error
 	if coll == "" {
 		vv := precedingUpdates.PubUpdates.Get(ns, key)
 		if vv == nil {
+			if cached, ok := bulkLoaded[compositeKey{ns, coll, key}]; ok {
+				return cached, nil
+			}
+			if prefetcher != nil {
+				if cached, ok := prefetcher.Get(ns, coll, key); ok {
+					return cached, nil
+				}
+			}
 			vv, err = db.GetState(ns, key)
 		}
 		return vv, err
@@ -132,7 +262,71 @@ func retrieveLatestState(ns, coll, key string,
 
 	vv = precedingUpdates.HashUpdates.Get(ns, coll, key)
 	if vv == nil {
+		if cached, ok := bulkLoaded[compositeKey{ns, coll, key}]; ok {
+			return cached, nil
+		}
+		if prefetcher != nil {
+			if cached, ok := prefetcher.Get(ns, coll, key); ok {
+				return cached, nil
+			}
+		}
 		vv, err = db.GetValueHash(ns, coll, []byte(key))
 	}
 	return vv, err
 }
+
+// bulkLoadLatestState walks txops and collects the composite keys whose final op still needs the latest
+// committed value or metadata merged in, excludes the ones already answered by precedingUpdates, and issues a
+// single db.GetStateMultipleKeys/db.GetValueHashes call per namespace/collection rather than one point lookup
+// per key. See FAB-11328.
+func bulkLoadLatestState(txops txOps, precedingUpdates *PubAndHashUpdates, db privacyenabledstate.DB) (map[compositeKey]*statedb.VersionedValue, error) {
+	pubKeysByNs := map[string][]string{}
+	hashedKeysByNsColl := map[compositeKey][]string{}
+
+	for ck, keyop := range txops {
+		if keyop.isDelete() || keyop.isUpsertAndMetadataUpdate() {
+			continue
+		}
+		if ck.coll == "" {
+			if precedingUpdates.PubUpdates.Get(ck.ns, ck.key) != nil {
+				continue
+			}
+			pubKeysByNs[ck.ns] = append(pubKeysByNs[ck.ns], ck.key)
+			continue
+		}
+		if precedingUpdates.HashUpdates.Get(ck.ns, ck.coll, ck.key) != nil {
+			continue
+		}
+		nsCollKey := compositeKey{ck.ns, ck.coll, ""}
+		hashedKeysByNsColl[nsCollKey] = append(hashedKeysByNsColl[nsCollKey], ck.key)
+	}
+
+	bulkLoaded := map[compositeKey]*statedb.VersionedValue{}
+	for ns, keys := range pubKeysByNs {
+		vals, err := db.GetStateMultipleKeys(ns, keys)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			if vals[i] != nil {
+				bulkLoaded[compositeKey{ns, "", key}] = vals[i]
+			}
+		}
+	}
+	for nsColl, keys := range hashedKeysByNsColl {
+		keyHashes := make([][]byte, len(keys))
+		for i, key := range keys {
+			keyHashes[i] = []byte(key)
+		}
+		vals, err := db.GetValueHashes(nsColl.ns, nsColl.coll, keyHashes)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			if vals[i] != nil {
+				bulkLoaded[compositeKey{nsColl.ns, nsColl.coll, key}] = vals[i]
+			}
+		}
+	}
+	return bulkLoaded, nil
+}
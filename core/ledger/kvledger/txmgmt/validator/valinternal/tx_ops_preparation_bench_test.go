@@ -0,0 +1,57 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package valinternal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/rwsetutil"
+	"github.com/hyperledger/fabric/protos/ledger/rwset/kvrwset"
+)
+
+// buildBenchRwset constructs a TxRwSet touching nsCount namespaces with writesPerNs public writes each, for
+// use in benchmarking the applyTxRwset fan-out introduced for ValidatorParallelism.
+func buildBenchRwset(nsCount, writesPerNs int) *rwsetutil.TxRwSet {
+	rwset := &rwsetutil.TxRwSet{}
+	for i := 0; i < nsCount; i++ {
+		nsRwSet := &rwsetutil.NsRwSet{
+			NameSpace: fmt.Sprintf("ns%d", i),
+			KvRwSet:   &kvrwset.KVRWSet{},
+		}
+		for j := 0; j < writesPerNs; j++ {
+			nsRwSet.KvRwSet.Writes = append(nsRwSet.KvRwSet.Writes, &kvrwset.KVWrite{
+				Key:   fmt.Sprintf("key%d", j),
+				Value: []byte("value"),
+			})
+		}
+		rwset.NsRwSets = append(rwset.NsRwSets, nsRwSet)
+	}
+	return rwset
+}
+
+func benchmarkApplyTxRwset(b *testing.B, parallelism int) {
+	prior := ValidatorParallelism
+	ValidatorParallelism = parallelism
+	defer func() { ValidatorParallelism = prior }()
+
+	rwset := buildBenchRwset(32, 50)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		txops := txOps{}
+		txops.applyTxRwset(rwset)
+	}
+}
+
+func BenchmarkApplyTxRwsetSerial(b *testing.B) {
+	benchmarkApplyTxRwset(b, 1)
+}
+
+func BenchmarkApplyTxRwsetParallel(b *testing.B) {
+	benchmarkApplyTxRwset(b, 8)
+}